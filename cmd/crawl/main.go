@@ -0,0 +1,66 @@
+// Command crawl is a minimal example of driving crawlbase.Crawler from
+// the command line, with optional resumable state.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+func main() {
+	seedFlag := flag.String("url", "", "seed url to start crawling from")
+	stateDir := flag.String("state", "", "directory to persist resumable crawl state in")
+	resume := flag.Bool("resume", false, "resume a previous crawl from -state")
+	flag.Parse()
+
+	cw := crawlbase.NewCrawler()
+
+	seed := *seedFlag
+	if *resume && *stateDir != "" {
+		resumedSeed, err := cw.Resume(*stateDir)
+		if err != nil {
+			log.Fatal("resume: ", err)
+		}
+		if resumedSeed != "" {
+			seed = resumedSeed
+		}
+	}
+
+	var startUrl *url.URL
+	if seed != "" {
+		var err error
+		startUrl, err = url.Parse(seed)
+		if err != nil {
+			log.Fatal("invalid -url: ", err)
+		}
+	}
+
+	if *stateDir != "" {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			log.Println("interrupted, snapshotting state before exit")
+			if err := cw.Snapshot(*stateDir, seed); err != nil {
+				log.Fatal("snapshot: ", err)
+			}
+			os.Exit(0)
+		}()
+	}
+
+	if err := cw.FetchSites(startUrl); err != nil {
+		log.Println("crawl stopped: ", err)
+	}
+
+	if *stateDir != "" {
+		if err := cw.Snapshot(*stateDir, seed); err != nil {
+			log.Fatal("snapshot: ", err)
+		}
+	}
+}
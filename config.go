@@ -0,0 +1,71 @@
+package crawlbase
+
+import "sync/atomic"
+
+// Crawler's WaitBetweenRequests, ScopeToDomain, and ValidSchemes can be
+// mutated at runtime (e.g. by the dashboard package) while a crawl is in
+// progress, so reads and writes go through cfgMu below rather than
+// touching the fields directly.
+
+// SetWaitBetweenRequests updates the politeness delay used by FetchSites
+// and, as the default interval, by pool.WorkerPool's per-host limiter.
+func (cw *Crawler) SetWaitBetweenRequests(ms int) {
+	cw.cfgMu.Lock()
+	defer cw.cfgMu.Unlock()
+	cw.WaitBetweenRequests = ms
+}
+
+// GetWaitBetweenRequests reads the current politeness delay.
+func (cw *Crawler) GetWaitBetweenRequests() int {
+	cw.cfgMu.Lock()
+	defer cw.cfgMu.Unlock()
+	return cw.WaitBetweenRequests
+}
+
+// SetScopeToDomain updates whether FetchSites restricts discovered links
+// to the seed url's domain.
+func (cw *Crawler) SetScopeToDomain(v bool) {
+	cw.cfgMu.Lock()
+	defer cw.cfgMu.Unlock()
+	cw.ScopeToDomain = v
+}
+
+// GetScopeToDomain reads the current domain-scoping setting.
+func (cw *Crawler) GetScopeToDomain() bool {
+	cw.cfgMu.Lock()
+	defer cw.cfgMu.Unlock()
+	return cw.ScopeToDomain
+}
+
+// SetValidSchemes updates the url schemes IsValidScheme accepts.
+func (cw *Crawler) SetValidSchemes(schemes []string) {
+	cw.cfgMu.Lock()
+	defer cw.cfgMu.Unlock()
+	cw.ValidSchemes = schemes
+}
+
+// GetValidSchemes returns a copy of the currently accepted url schemes.
+func (cw *Crawler) GetValidSchemes() []string {
+	cw.cfgMu.Lock()
+	defer cw.cfgMu.Unlock()
+	cp := make([]string, len(cw.ValidSchemes))
+	copy(cp, cw.ValidSchemes)
+	return cp
+}
+
+// PageCount is updated from multiple goroutines by pool.WorkerPool, so
+// reads and increments both go through atomic ops on the field rather
+// than cfgMu (a mutex would only protect callers that remember to take
+// it, and the dashboard doesn't).
+
+// GetPageCount returns the number of pages crawled so far.
+func (cw *Crawler) GetPageCount() uint64 {
+	return atomic.LoadUint64(&cw.PageCount)
+}
+
+// IncrementPageCount atomically increments PageCount and returns the new
+// value. FetchSites and pool.WorkerPool both call this instead of
+// touching PageCount directly.
+func (cw *Crawler) IncrementPageCount() uint64 {
+	return atomic.AddUint64(&cw.PageCount, 1)
+}
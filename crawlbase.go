@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
@@ -15,8 +16,8 @@ import (
 	"os"
 	"path"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
@@ -33,6 +34,15 @@ type Page struct {
 	Request      *PageRequest
 	RespInfo     ResponseInfo
 	Error        string
+	BodySize     int64
+	BodySHA1     string
+	RetryCount   int
+	// BodyPath points at the on-disk response body: a temp file while the
+	// page is in flight, renamed to <uid>.respbin once SavePage runs.
+	BodyPath string `json:"-"`
+	// ResponseBody is only populated by LoadPage(path, true); it is never
+	// filled in during a live crawl so response bodies don't have to be
+	// held in memory.
 	ResponseBody []byte `json:"-"`
 	RequestBody  []byte `json:"-"`
 }
@@ -97,13 +107,39 @@ type Crawler struct {
 	Client              http.Client
 	IncludeHiddenLinks  bool
 	WaitBetweenRequests int
-	Links               map[string]bool
+	Queue               VisitQueue
 	BeforeCrawlFn       func(string) (string, error)
 	AfterCrawlFn        func(*Page, error) ([]string, error)
 	ValidSchemes        []string
 	PageCount           uint64
 	StorageFolder       string
 	ScopeToDomain       bool
+	// MaxParseBodySize caps how large a response body may be before it is
+	// only fingerprinted (BodySize/BodySHA1) instead of parsed with
+	// goquery. Zero means unlimited.
+	MaxParseBodySize int64
+	// ParseableMIMETypes lists the Content-Types PageFromResponse will run
+	// through goquery; anything else is only fingerprinted, the same as
+	// exceeding MaxParseBodySize.
+	ParseableMIMETypes []string
+	// transportOpts is set by SetTransport and consulted by GetPage's
+	// retry/backoff logic.
+	transportOpts TransportOptions
+
+	// RobotsPolicy controls whether robots.txt is consulted by
+	// AddAllLinks/AddLinksMatchingDomain and CrawlDelayFor.
+	RobotsPolicy RobotsMode
+	robotsMu     sync.Mutex
+	robotsCache  map[string]*robotsPolicy
+
+	// Sink, when set, makes SavePage write pages through it (e.g. as
+	// WARC records) instead of the default .httpi/.respbin files. As with
+	// those files, SavePage writes nothing when StorageFolder is empty.
+	Sink PageSink
+
+	// cfgMu guards WaitBetweenRequests, ScopeToDomain, and ValidSchemes,
+	// which can be mutated at runtime (see config.go).
+	cfgMu sync.Mutex
 }
 
 type DNSScanner struct {
@@ -126,28 +162,52 @@ func NewCrawler() *Crawler {
 	cw.Header.Set("User-Agent", headerUserAgentChrome)
 	cw.Client.Timeout = 30 * time.Second
 	cw.WaitBetweenRequests = 1 * 1000
-	cw.Links = map[string]bool{}
+	cw.Queue = NewMemoryVisitQueue()
 	cw.ValidSchemes = []string{"http", "https"}
 	cw.StorageFolder = "./storage"
+	cw.MaxParseBodySize = 20 * 1024 * 1024 // 20MB
+	cw.ParseableMIMETypes = []string{"text/html", "application/xhtml+xml"}
 	return &cw
 }
 
+// GetPage fetches crawlUrl, streaming its body to a temp file under
+// StorageFolder (see Page.BodyPath). Callers that don't pass the
+// returned page to SavePage must call DiscardPage themselves, or that
+// temp file is never cleaned up.
 func (c *Crawler) GetPage(crawlUrl, method string) (*Page, error) {
 	timeStart := time.Now()
-	req, err := http.NewRequest(method, crawlUrl, nil)
-	if err != nil {
-		log.Println("GetPage ", err)
-		return nil, err
-	}
 
-	for k, v := range c.Header {
-		req.Header.Set(k, v[0])
-	}
+	var req *http.Request
+	var res *http.Response
+	var err error
+	retries := 0
+
+	for {
+		req, err = http.NewRequest(method, crawlUrl, nil)
+		if err != nil {
+			log.Println("GetPage ", err)
+			return nil, err
+		}
+
+		for k, v := range c.Header {
+			req.Header.Set(k, v[0])
+		}
+
+		res, err = c.Client.Do(req)
 
-	res, err := c.Client.Do(req)
+		if !c.shouldRetry(res, err, retries) {
+			break
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		retries++
+		time.Sleep(c.backoffDuration(retries))
+	}
 
 	timeDur := time.Now().Sub(timeStart)
 	page := c.PageFromResponse(req, res, timeDur)
+	page.RetryCount = retries
 
 	if err != nil {
 		urlerror, ok := err.(*url.Error)
@@ -162,44 +222,29 @@ func (c *Crawler) GetPage(crawlUrl, method string) (*Page, error) {
 }
 
 func (cw *Crawler) FetchSites(startUrl *url.URL) error {
-	crawlStartUrlFirst := false
-
 	if startUrl != nil {
-		cw.AddAllLinks([]string{startUrl.String()})
-
-		if !cw.IsCrawled(startUrl.String()) {
-			crawlStartUrlFirst = true
-		} else {
-			log.Println("start url already crawled, skipping: ", startUrl.String())
+		if err := cw.Queue.Enqueue(startUrl.String()); err != nil {
+			return err
 		}
 	}
 
 	for {
-		urlStr := ""
-		found := false
-		if !crawlStartUrlFirst || startUrl == nil {
-			urlStr, found = cw.GetNextLink()
-		} else {
-			urlStr = startUrl.String()
-			crawlStartUrlFirst = false
-			found = true
+		urlStr, found, err := cw.Queue.Dequeue()
+		if err != nil {
+			return err
 		}
-
 		if !found {
-			log.Println("no more links. crawled ", cw.PageCount, "page(s).")
+			log.Println("no more links. crawled ", cw.GetPageCount(), "page(s).")
 			return nil // done
 		}
 
 		if cw.BeforeCrawlFn != nil {
-			url, err := cw.BeforeCrawlFn(urlStr)
+			urlStr, err = cw.BeforeCrawlFn(urlStr)
 			if err != nil {
 				return err
 			}
-			urlStr = url
 		}
 
-		cw.Links[urlStr] = true
-
 		nextUrl, err := url.Parse(urlStr)
 		if err != nil {
 			log.Println("error while parsing url: " + err.Error())
@@ -211,7 +256,7 @@ func (cw *Crawler) FetchSites(startUrl *url.URL) error {
 		}
 
 		page, err := cw.GetPage(urlStr, "GET")
-		log.Println("fetched site: "+urlStr, page.Response.StatusCode, len(page.ResponseBody))
+		log.Println("fetched site: "+urlStr, page.Response.StatusCode, page.BodySize)
 
 		userLinks := page.RespInfo.Hrefs
 		if cw.AfterCrawlFn != nil {
@@ -223,36 +268,43 @@ func (cw *Crawler) FetchSites(startUrl *url.URL) error {
 		}
 
 		cw.SavePage(page)
-		cw.PageCount += 1
+		cw.IncrementPageCount()
 
-		if startUrl !=nil && cw.ScopeToDomain {
-			cw.AddLinksMatchingDomain(userLinks, startUrl)
-		} else {
-			cw.AddAllLinks(userLinks)
+		if err := cw.Queue.MarkCrawled(urlStr); err != nil {
+			log.Println("MarkCrawled: ", err)
 		}
 
-		time.Sleep(time.Duration(cw.WaitBetweenRequests) * time.Millisecond)
+		cw.AddDiscoveredLinks(userLinks, startUrl)
+
+		time.Sleep(time.Duration(cw.GetWaitBetweenRequests()) * time.Millisecond)
 	}
 }
 
 func (cw *Crawler) IsCrawled(url string) bool {
-	val, hasLink := cw.Links[url]
-	if hasLink && val == true {
-		return true
+	crawled, err := cw.Queue.IsCrawled(url)
+	if err != nil {
+		log.Println("IsCrawled: ", err)
+		return false
 	}
-	return false
+	return crawled
 }
 
 func (cw *Crawler) AddCrawledLinks(links []string) {
 	for _, newLink := range links {
-		cw.Links[newLink] = true
+		if err := cw.Queue.MarkCrawled(newLink); err != nil {
+			log.Println("AddCrawledLinks: ", err)
+		}
 	}
 }
 
 func (cw *Crawler) AddAllLinks(links []string) {
 	for _, newLink := range links {
-		isCrawled := cw.IsCrawled(newLink)
-		cw.Links[newLink] = isCrawled
+		if !cw.isAllowedByRobots(newLink) {
+			continue
+		}
+		if err := cw.Queue.Enqueue(newLink); err != nil {
+			log.Println("AddAllLinks: ", err)
+		}
 	}
 }
 
@@ -268,17 +320,32 @@ func (cw *Crawler) AddLinksMatchingDomain(links []string, startUrl *url.URL) {
 	}
 }
 
+// AddDiscoveredLinks applies the same filtering FetchSites applies to
+// links found on a page: domain scoping against fromUrl when
+// ScopeToDomain is set (and fromUrl is known), then AddAllLinks's
+// robots.txt check. FetchSites and pool.WorkerPool both call this so
+// scope/robots handling can't drift between the sequential and
+// concurrent crawl paths.
+func (cw *Crawler) AddDiscoveredLinks(links []string, fromUrl *url.URL) {
+	if fromUrl != nil && cw.GetScopeToDomain() {
+		cw.AddLinksMatchingDomain(links, fromUrl)
+	} else {
+		cw.AddAllLinks(links)
+	}
+}
+
 func (cw *Crawler) IsValidScheme(url *url.URL) bool {
-	return ContainsString(cw.ValidSchemes, url.Scheme)
+	return ContainsString(cw.GetValidSchemes(), url.Scheme)
 }
 
-func PageFromData(data []byte, url *url.URL, includeHiddenLinks bool) *Page {
+// PageFromData builds a Page's RespInfo by running goquery over body,
+// which must be positioned at the start of the document. It is the
+// caller's responsibility to rewind body afterwards if it needs to be
+// read again (e.g. SavePage persisting it to disk).
+func PageFromData(body *os.File, url *url.URL, includeHiddenLinks bool) *Page {
 	page := Page{}
 
-	page.ResponseBody = data
-
-	ioreader := bytes.NewReader(data)
-	doc, err := goquery.NewDocumentFromReader(ioreader)
+	doc, err := goquery.NewDocumentFromReader(body)
 	if err != nil {
 		log.Println("PageFromData: ", err)
 	}
@@ -296,22 +363,64 @@ func PageFromData(data []byte, url *url.URL, includeHiddenLinks bool) *Page {
 	return &page
 }
 
+// streamToTempFile copies body into a new temp file under folder,
+// fingerprinting it (size + sha1) as it goes, and returns the file
+// rewound to its start.
+func streamToTempFile(body io.Reader, folder string) (*os.File, int64, string, error) {
+	if folder == "" {
+		folder = os.TempDir()
+	}
+	if err := os.MkdirAll(folder, 0777); err != nil {
+		return nil, 0, "", err
+	}
+	f, err := ioutil.TempFile(folder, "resp-*.tmp")
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	h := sha1.New()
+	size, err := io.Copy(io.MultiWriter(f, h), body)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, "", err
+	}
+
+	return f, size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func (c *Crawler) PageFromResponse(req *http.Request, res *http.Response, timeDur time.Duration) *Page {
 	page := &Page{}
 	page.Response = &PageResponse{}
 	page.Request = &PageRequest{}
 
-	body := []byte{}
-
 	var err error = nil
 
 	if res != nil {
-		body, err = ioutil.ReadAll(res.Body)
-		if err == nil {
-			page = PageFromData(body, req.URL, c.IncludeHiddenLinks)
+		mime := GetContentMime(res.Header)
+
+		var tmpFile *os.File
+		tmpFile, page.BodySize, page.BodySHA1, err = streamToTempFile(res.Body, c.StorageFolder)
+		if err != nil {
+			log.Println("PageFromResponse: ", err)
+		} else {
+			withinSizeCap := c.MaxParseBodySize <= 0 || page.BodySize <= c.MaxParseBodySize
+			isParseableMIME := len(c.ParseableMIMETypes) == 0 || ContainsString(c.ParseableMIMETypes, mime)
+			if withinSizeCap && isParseableMIME {
+				parsed := PageFromData(tmpFile, req.URL, c.IncludeHiddenLinks)
+				page.RespInfo = parsed.RespInfo
+				tmpFile.Seek(0, io.SeekStart)
+			}
+			page.BodyPath = tmpFile.Name()
+			tmpFile.Close()
 		}
 
-		page.Response.ContentMIME = GetContentMime(res.Header)
+		page.Response.ContentMIME = mime
 		page.Response.StatusCode = res.StatusCode
 		page.Response.Header = res.Header
 		page.Response.Proto = res.Proto
@@ -353,15 +462,6 @@ func ContainsString(arr []string, key string) bool {
 	return false
 }
 
-func (c *Crawler) GetNextLink() (string, bool) {
-	for i, l := range c.Links {
-		if l == false {
-			return i, true
-		}
-	}
-	return "", false
-}
-
 func (cw *Crawler) LoadPages(folderpath string) (int, error) {
 	if folderpath == "" {
 		return 0, nil
@@ -396,11 +496,26 @@ func (cw *Crawler) LoadPages(folderpath string) (int, error) {
 	return readCount, nil
 }
 
+// RemoveLinksNotSameHost prunes any known url that isn't on baseUrl's
+// domain. It only works against queues that can enumerate their known
+// urls (e.g. MemoryVisitQueue); on other queue implementations it logs
+// and does nothing, since a disk-backed queue is not expected to support
+// cheap enumeration.
 func (cw *Crawler) RemoveLinksNotSameHost(baseUrl *url.URL) {
-	for k, _ := range cw.Links {
+	lister, ok := cw.Queue.(interface{ Links() map[string]bool })
+	if !ok {
+		log.Println("RemoveLinksNotSameHost: queue does not support link enumeration")
+		return
+	}
+	deleter, ok := cw.Queue.(interface{ Delete(string) })
+	if !ok {
+		log.Println("RemoveLinksNotSameHost: queue does not support deletion")
+		return
+	}
+	for k := range lister.Links() {
 		pUrl, err := url.Parse(k)
 		if err != nil || !IsSameDomain(baseUrl, pUrl) {
-			delete(cw.Links, k)
+			deleter.Delete(k)
 		}
 	}
 }
@@ -465,6 +580,7 @@ func LoadPage(filepath string, withContent bool) (*Page, error) {
 			log.Println(err)
 		}
 		page.ResponseBody = respbinContent
+		page.BodyPath = respbinfile
 	}
 
 	return &page, nil
@@ -473,22 +589,43 @@ func LoadPage(filepath string, withContent bool) (*Page, error) {
 func (c *Crawler) SavePage(page *Page) {
 	if c.StorageFolder == "" {
 		// dont save if storagepath is empty
+		c.DiscardPage(page)
 		return
 	}
 	if page == nil {
 		log.Fatal("SavePage: page is null")
 	}
-	_, err := os.Stat("./storage")
-	if err != nil && os.IsNotExist(err) {
-		err := os.Mkdir("storage", 0777)
-		checkFatal(err)
+
+	if c.Sink != nil {
+		if err := c.writeToSink(page); err != nil {
+			log.Println("SavePage: ", err)
+		}
+		c.DiscardPage(page)
+		return
 	}
 
-	fileName := strconv.FormatInt(int64(page.CrawlTime), 10)
-	filePath := path.Join(c.StorageFolder, fileName+".respbin")
-	err = ioutil.WriteFile(filePath, page.ResponseBody, 0666)
+	// MkdirAll is safe to call repeatedly from concurrent workers, unlike
+	// the previous Stat-then-Mkdir, which could race.
+	err := os.MkdirAll(c.StorageFolder, 0777)
 	checkFatal(err)
 
+	// Uid is derived from the page url and is stable per-page, unlike
+	// CrawlTime (second resolution), which collides under concurrent
+	// fetches.
+	fileName := page.Uid
+	filePath := path.Join(c.StorageFolder, fileName+".respbin")
+	if page.BodyPath != "" {
+		// The body already landed on disk as a temp file while it was
+		// fetched; just move it into place instead of copying it again.
+		if err := os.Rename(page.BodyPath, filePath); err != nil {
+			checkFatal(err)
+		}
+		page.BodyPath = filePath
+	} else {
+		err = ioutil.WriteFile(filePath, page.ResponseBody, 0666)
+		checkFatal(err)
+	}
+
 	content, err := json.MarshalIndent(page, "", "  ")
 	checkFatal(err)
 	filePath = path.Join(c.StorageFolder, fileName+".httpi")
@@ -500,6 +637,43 @@ func (c *Crawler) SavePage(page *Page) {
 	*/
 }
 
+// DiscardPage removes the on-disk temp file backing page's response
+// body. Callers that fetch a page via GetPage but decide not to keep it
+// (a probe, an AfterCrawlFn that drops the page) must call this, or
+// SavePage, or the temp file streamToTempFile created is never cleaned
+// up. It is a no-op if the body was already moved into place by
+// SavePage or never touched disk.
+func (c *Crawler) DiscardPage(page *Page) error {
+	if page == nil || page.BodyPath == "" {
+		return nil
+	}
+	if strings.HasSuffix(page.BodyPath, ".respbin") {
+		// already in place: moved there by SavePage or found by LoadPage
+		return nil
+	}
+	err := os.Remove(page.BodyPath)
+	page.BodyPath = ""
+	return err
+}
+
+// writeToSink hands page's body to c.Sink, reading it from BodyPath when
+// that is set (the temp file from the fetch, or the .respbin file that
+// LoadPage(path, true) read), or from the in-memory ResponseBody otherwise.
+func (c *Crawler) writeToSink(page *Page) error {
+	var body io.Reader
+	if page.BodyPath != "" {
+		f, err := os.Open(page.BodyPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		body = f
+	} else {
+		body = bytes.NewReader(page.ResponseBody)
+	}
+	return c.Sink.WritePage(page, nil, body)
+}
+
 func checkFatal(e error) {
 	if e != nil {
 		log.Fatal(e)
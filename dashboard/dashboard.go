@@ -0,0 +1,172 @@
+// Package dashboard exposes a small HTTP control surface for watching and
+// steering a running crawl: live status, pause/resume/stop, adding or
+// removing queued urls, and tweaking a few Crawler config fields.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/BlackEspresso/crawlbase/pool"
+)
+
+type statusResponse struct {
+	PageCount           uint64
+	QueueDepth          int // pending urls; -1 if the queue can't report it
+	WaitBetweenRequests int
+	ScopeToDomain       bool
+	ValidSchemes        []string
+}
+
+// Serve runs an http.Server on addr exposing /status, /pause, /resume,
+// /stop, /queue, /config, and a minimal auto-refreshing HTML page at /.
+// It blocks until the server stops.
+func Serve(cw *crawlbase.Crawler, wp *pool.WorkerPool, addr string) error {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", handleIndex)
+	mux.HandleFunc("/status", handleStatus(cw))
+	mux.HandleFunc("/pause", handlePause(wp))
+	mux.HandleFunc("/resume", handleResume(wp))
+	mux.HandleFunc("/stop", handleStop(wp))
+	mux.HandleFunc("/queue", handleQueue(cw))
+	mux.HandleFunc("/config", handleConfig(cw))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	return server.ListenAndServe()
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, indexHTML)
+}
+
+func handleStatus(cw *crawlbase.Crawler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := statusResponse{
+			PageCount:           cw.GetPageCount(),
+			QueueDepth:          queueDepth(cw),
+			WaitBetweenRequests: cw.GetWaitBetweenRequests(),
+			ScopeToDomain:       cw.GetScopeToDomain(),
+			ValidSchemes:        cw.GetValidSchemes(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// queueDepth reports how many urls are still pending, for queues that
+// can enumerate their state (e.g. crawlbase.MemoryVisitQueue). It returns
+// -1 when the configured queue can't answer cheaply.
+func queueDepth(cw *crawlbase.Crawler) int {
+	lister, ok := cw.Queue.(interface{ Links() map[string]bool })
+	if !ok {
+		return -1
+	}
+	pending := 0
+	for _, crawled := range lister.Links() {
+		if !crawled {
+			pending++
+		}
+	}
+	return pending
+}
+
+func handlePause(wp *pool.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wp.Pause()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleResume(wp *pool.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wp.Resume()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleStop(wp *pool.WorkerPool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		go wp.Stop()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleQueue(cw *crawlbase.Crawler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		url := r.URL.Query().Get("url")
+		if url == "" {
+			http.Error(w, "missing url query param", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			cw.AddAllLinks([]string{url})
+		case http.MethodDelete:
+			deleter, ok := cw.Queue.(interface{ Delete(string) })
+			if !ok {
+				http.Error(w, "queue does not support removal", http.StatusNotImplemented)
+				return
+			}
+			deleter.Delete(url)
+		default:
+			http.Error(w, "use POST to add or DELETE to remove", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type configUpdate struct {
+	WaitBetweenRequests *int
+	ScopeToDomain       *bool
+	ValidSchemes        []string
+}
+
+func handleConfig(cw *crawlbase.Crawler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var update configUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if update.WaitBetweenRequests != nil {
+			cw.SetWaitBetweenRequests(*update.WaitBetweenRequests)
+		}
+		if update.ScopeToDomain != nil {
+			cw.SetScopeToDomain(*update.ScopeToDomain)
+		}
+		if update.ValidSchemes != nil {
+			cw.SetValidSchemes(update.ValidSchemes)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+const indexHTML = `<!DOCTYPE html>
+<html>
+<head><title>crawlbase dashboard</title></head>
+<body>
+<h1>crawlbase</h1>
+<pre id="status">loading...</pre>
+<script>
+function refresh() {
+  fetch("/status").then(function(r) { return r.json(); }).then(function(s) {
+    document.getElementById("status").textContent = JSON.stringify(s, null, 2);
+  });
+}
+setInterval(refresh, 2000);
+refresh();
+</script>
+</body>
+</html>
+`
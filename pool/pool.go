@@ -0,0 +1,184 @@
+// Package pool runs a crawlbase.Crawler's visit queue through a fixed
+// number of concurrent workers, applying politeness per-host instead of
+// globally.
+package pool
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+// Event is emitted on WorkerPool.Events after each crawl attempt, whether
+// it succeeded or not.
+type Event struct {
+	URL  string
+	Page *crawlbase.Page
+	Err  error
+}
+
+// hostLimiter is a simple token-bucket-of-one: it lets a caller through
+// at most once per interval, blocking callers in between.
+type hostLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (h *hostLimiter) wait(interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if now.Before(h.next) {
+		time.Sleep(h.next.Sub(now))
+		now = time.Now()
+	}
+	h.next = now.Add(interval)
+}
+
+// WorkerPool runs n goroutines pulling urls from cw.Queue, fetching them
+// through cw.GetPage and saving them through cw.SavePage, while enforcing
+// cw.WaitBetweenRequests per host rather than globally.
+type WorkerPool struct {
+	cw *crawlbase.Crawler
+	n  int
+
+	Events chan Event
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	paused   int32
+	wg       sync.WaitGroup
+
+	limitersMu sync.Mutex
+	limiters   map[string]*hostLimiter
+}
+
+// New creates a WorkerPool that will run n concurrent workers against cw
+// once Start is called.
+func New(cw *crawlbase.Crawler, n int) *WorkerPool {
+	return &WorkerPool{
+		cw:       cw,
+		n:        n,
+		Events:   make(chan Event, n*2),
+		stopCh:   make(chan struct{}),
+		limiters: map[string]*hostLimiter{},
+	}
+}
+
+// Start launches the worker goroutines. It returns immediately; crawl
+// progress is reported on Events.
+func (p *WorkerPool) Start() {
+	for i := 0; i < p.n; i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+}
+
+// Stop signals all workers to exit, waits for them, and closes Events.
+// It is safe to call more than once (e.g. from a /stop endpoint a client
+// retried); only the first call does anything.
+func (p *WorkerPool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+		p.wg.Wait()
+		close(p.Events)
+	})
+}
+
+// Pause keeps workers alive but stops them from dequeuing new urls.
+func (p *WorkerPool) Pause() {
+	atomic.StoreInt32(&p.paused, 1)
+}
+
+// Resume undoes Pause.
+func (p *WorkerPool) Resume() {
+	atomic.StoreInt32(&p.paused, 0)
+}
+
+func (p *WorkerPool) waitIfPaused() bool {
+	for atomic.LoadInt32(&p.paused) == 1 {
+		select {
+		case <-p.stopCh:
+			return false
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func (p *WorkerPool) worker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		default:
+		}
+
+		if !p.waitIfPaused() {
+			return
+		}
+
+		urlStr, found, err := p.cw.Queue.Dequeue()
+		if err != nil {
+			p.Events <- Event{Err: err}
+			continue
+		}
+		if !found {
+			select {
+			case <-p.stopCh:
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+			continue
+		}
+
+		if p.cw.BeforeCrawlFn != nil {
+			urlStr, err = p.cw.BeforeCrawlFn(urlStr)
+			if err != nil {
+				p.Events <- Event{URL: urlStr, Err: err}
+				continue
+			}
+		}
+
+		nextUrl, err := url.Parse(urlStr)
+		if err != nil || !p.cw.IsValidScheme(nextUrl) {
+			continue
+		}
+
+		p.limiterFor(nextUrl.Host).wait(p.cw.CrawlDelayFor(nextUrl))
+
+		page, fetchErr := p.cw.GetPage(urlStr, "GET")
+
+		userLinks := page.RespInfo.Hrefs
+		if p.cw.AfterCrawlFn != nil {
+			userLinks, fetchErr = p.cw.AfterCrawlFn(page, fetchErr)
+		}
+
+		p.cw.SavePage(page)
+		p.cw.IncrementPageCount()
+
+		if err := p.cw.Queue.MarkCrawled(urlStr); err != nil {
+			p.Events <- Event{URL: urlStr, Page: page, Err: err}
+			continue
+		}
+
+		p.cw.AddDiscoveredLinks(userLinks, nextUrl)
+
+		p.Events <- Event{URL: urlStr, Page: page, Err: fetchErr}
+	}
+}
+
+func (p *WorkerPool) limiterFor(host string) *hostLimiter {
+	p.limitersMu.Lock()
+	defer p.limitersMu.Unlock()
+	l, ok := p.limiters[host]
+	if !ok {
+		l = &hostLimiter{}
+		p.limiters[host] = l
+	}
+	return l
+}
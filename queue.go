@@ -0,0 +1,245 @@
+package crawlbase
+
+import (
+	"bufio"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// VisitQueue tracks which URLs have been discovered, are still pending a
+// fetch, or have already been crawled. Crawler drives a crawl purely
+// through this interface so the queue implementation - in-memory or
+// disk-backed - can be swapped without touching FetchSites.
+type VisitQueue interface {
+	// Enqueue adds url to the queue if it hasn't been seen before. It is
+	// a no-op if the url is already known.
+	Enqueue(url string) error
+	// Dequeue pops the next pending url. ok is false once the queue is
+	// empty.
+	Dequeue() (url string, ok bool, err error)
+	// MarkCrawled records url as fetched, whether or not it was ever
+	// enqueued (e.g. when rehydrating from previously saved pages).
+	MarkCrawled(url string) error
+	// IsKnown reports whether url has been enqueued or marked crawled
+	// before.
+	IsKnown(url string) (bool, error)
+	// IsCrawled reports whether url has actually been fetched (MarkCrawled
+	// called on it), as opposed to merely enqueued and still pending.
+	IsCrawled(url string) (bool, error)
+}
+
+// MemoryVisitQueue is the original map-backed queue. It keeps every url it
+// has ever seen in RAM, which is fine for small to medium crawls.
+type MemoryVisitQueue struct {
+	mu      sync.Mutex
+	known   map[string]bool // url -> crawled
+	pending []string
+}
+
+func NewMemoryVisitQueue() *MemoryVisitQueue {
+	return &MemoryVisitQueue{
+		known: map[string]bool{},
+	}
+}
+
+func (q *MemoryVisitQueue) Enqueue(url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.known[url]; ok {
+		return nil
+	}
+	q.known[url] = false
+	q.pending = append(q.pending, url)
+	return nil
+}
+
+func (q *MemoryVisitQueue) Dequeue() (string, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) > 0 {
+		url := q.pending[0]
+		q.pending = q.pending[1:]
+		if q.known[url] {
+			// already marked crawled (e.g. by RestoreQueue + the .httpi
+			// backfill in Resume racing a stale snapshot) - skip it
+			// instead of re-fetching.
+			continue
+		}
+		return url, true, nil
+	}
+	return "", false, nil
+}
+
+func (q *MemoryVisitQueue) MarkCrawled(url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.known[url] = true
+	return nil
+}
+
+func (q *MemoryVisitQueue) IsKnown(url string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.known[url]
+	return ok, nil
+}
+
+func (q *MemoryVisitQueue) IsCrawled(url string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.known[url], nil
+}
+
+// Links returns a copy of the known-url map, crawled state included. It
+// lets tooling such as Crawler.RemoveLinksNotSameHost keep pruning the
+// queue the way it pruned the old Links map.
+func (q *MemoryVisitQueue) Links() map[string]bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cp := make(map[string]bool, len(q.known))
+	for k, v := range q.known {
+		cp[k] = v
+	}
+	return cp
+}
+
+// Delete removes a url from the queue entirely.
+func (q *MemoryVisitQueue) Delete(url string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.known, url)
+	for i, p := range q.pending {
+		if p == url {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			break
+		}
+	}
+}
+
+// FileVisitQueue is a disk-backed VisitQueue for crawls with more pending
+// URLs than comfortably fit in RAM. Pending urls are appended to a segment
+// file on disk and replayed in order on Dequeue; IsKnown is answered from
+// a small in-memory hash index instead of rescanning the file.
+type FileVisitQueue struct {
+	mu      sync.Mutex
+	segPath string
+	segFile *os.File
+	reader  *bufio.Reader
+	index   map[uint64]bool // hash(url) -> seen
+	crawled map[uint64]bool // hash(url) -> crawled
+}
+
+// NewFileVisitQueue opens (creating if necessary) a queue segment file
+// under folder. The segment file is append-only; any urls already in it
+// from a previous run are replayed from the start as the queue is
+// dequeued, and are also loaded into index up front so Enqueue
+// recognizes them as already-seen instead of appending duplicate lines.
+func NewFileVisitQueue(folder string) (*FileVisitQueue, error) {
+	if err := os.MkdirAll(folder, 0777); err != nil {
+		return nil, err
+	}
+	segPath := filepath.Join(folder, "queue.seg")
+	segFile, err := os.OpenFile(segPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	readFile, err := os.Open(segPath)
+	if err != nil {
+		segFile.Close()
+		return nil, err
+	}
+
+	index := map[uint64]bool{}
+	scanner := bufio.NewScanner(readFile)
+	for scanner.Scan() {
+		index[urlHash(scanner.Text())] = true
+	}
+	if err := scanner.Err(); err != nil {
+		segFile.Close()
+		readFile.Close()
+		return nil, err
+	}
+	if _, err := readFile.Seek(0, io.SeekStart); err != nil {
+		segFile.Close()
+		readFile.Close()
+		return nil, err
+	}
+
+	return &FileVisitQueue{
+		segPath: segPath,
+		segFile: segFile,
+		reader:  bufio.NewReader(readFile),
+		index:   index,
+		crawled: map[uint64]bool{},
+	}, nil
+}
+
+func urlHash(url string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(url))
+	return h.Sum64()
+}
+
+func (q *FileVisitQueue) Enqueue(url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	hash := urlHash(url)
+	if _, ok := q.index[hash]; ok {
+		return nil
+	}
+	q.index[hash] = true
+	_, err := q.segFile.WriteString(url + "\n")
+	return err
+}
+
+func (q *FileVisitQueue) Dequeue() (string, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		line, err := q.reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		url := strings.TrimRight(line, "\n")
+		if q.crawled[urlHash(url)] {
+			continue
+		}
+		return url, true, nil
+	}
+}
+
+func (q *FileVisitQueue) MarkCrawled(url string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	hash := urlHash(url)
+	q.index[hash] = true
+	q.crawled[hash] = true
+	return nil
+}
+
+func (q *FileVisitQueue) IsKnown(url string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.index[urlHash(url)]
+	return ok, nil
+}
+
+func (q *FileVisitQueue) IsCrawled(url string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.crawled[urlHash(url)], nil
+}
+
+// Close releases the underlying segment file handles.
+func (q *FileVisitQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.segFile.Close()
+}
@@ -0,0 +1,147 @@
+package crawlbase
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// QueueSnapshotter is implemented by VisitQueues that can serialize and
+// restore their full pending/crawled state. MemoryVisitQueue implements
+// it; FileVisitQueue doesn't need to, since its segment file already
+// persists incrementally across runs.
+type QueueSnapshotter interface {
+	SnapshotQueue() (pending []string, crawled []string, err error)
+	RestoreQueue(pending []string, crawled []string) error
+}
+
+// SnapshotQueue returns the pending urls (in dequeue order) and the urls
+// already marked crawled.
+func (q *MemoryVisitQueue) SnapshotQueue() ([]string, []string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := make([]string, len(q.pending))
+	copy(pending, q.pending)
+
+	crawled := []string{}
+	for url, done := range q.known {
+		if done {
+			crawled = append(crawled, url)
+		}
+	}
+
+	return pending, crawled, nil
+}
+
+// RestoreQueue repopulates the queue from a prior SnapshotQueue result.
+func (q *MemoryVisitQueue) RestoreQueue(pending []string, crawled []string) error {
+	for _, url := range crawled {
+		if err := q.MarkCrawled(url); err != nil {
+			return err
+		}
+	}
+	for _, url := range pending {
+		if err := q.Enqueue(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// crawlerState is what Snapshot/Resume persist to stateDir/state.json.
+type crawlerState struct {
+	SeedURL             string
+	ScopeToDomain       bool
+	WaitBetweenRequests int
+	PageCount           uint64
+	Pending             []string
+	Crawled             []string
+}
+
+// Snapshot persists the crawler's seed/scope config, PageCount, and (when
+// the configured Queue supports it) its full pending/crawled url state to
+// stateDir, so a later Resume(stateDir) can continue the same crawl.
+func (cw *Crawler) Snapshot(stateDir string, seedURL string) error {
+	if err := os.MkdirAll(stateDir, 0777); err != nil {
+		return err
+	}
+
+	state := crawlerState{
+		SeedURL:             seedURL,
+		ScopeToDomain:       cw.GetScopeToDomain(),
+		WaitBetweenRequests: cw.GetWaitBetweenRequests(),
+		PageCount:           cw.GetPageCount(),
+	}
+
+	if snap, ok := cw.Queue.(QueueSnapshotter); ok {
+		pending, crawled, err := snap.SnapshotQueue()
+		if err != nil {
+			return err
+		}
+		state.Pending = pending
+		state.Crawled = crawled
+	}
+
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(stateDir, "state.json"), content, 0666)
+}
+
+// Resume rehydrates a crawler from a directory previously written by
+// Snapshot, restoring PageCount, scope/seed config, and as much of the
+// queue state as the configured Queue knows how to restore. It then
+// walks StorageFolder's .httpi files so pages fetched since the last
+// Snapshot - including right up to a crash, since Snapshot is normally
+// only called on a clean exit - are still marked crawled rather than
+// re-fetched. That walk runs even when stateDir has no saved state yet,
+// so a crash before the first successful Snapshot still resumes from
+// whatever was fetched. It returns the seed url that was active when
+// Snapshot was taken, or "" if stateDir has no saved state yet.
+func (cw *Crawler) Resume(stateDir string) (string, error) {
+	var state crawlerState
+
+	statePath := path.Join(stateDir, "state.json")
+	content, err := ioutil.ReadFile(statePath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(content, &state); err != nil {
+			return "", err
+		}
+
+		cw.PageCount = state.PageCount
+		cw.SetScopeToDomain(state.ScopeToDomain)
+		if state.WaitBetweenRequests != 0 {
+			cw.SetWaitBetweenRequests(state.WaitBetweenRequests)
+		}
+
+		if snap, ok := cw.Queue.(QueueSnapshotter); ok {
+			if err := snap.RestoreQueue(state.Pending, state.Crawled); err != nil {
+				return "", err
+			}
+		}
+	case !os.IsNotExist(err):
+		return "", err
+	}
+
+	files, err := GetPageInfoFiles(cw.StorageFolder)
+	if err != nil && !os.IsNotExist(err) {
+		return state.SeedURL, err
+	}
+	for _, file := range files {
+		p, err := LoadPage(file, false)
+		if err != nil {
+			log.Println("Resume: ", err)
+			continue
+		}
+		if err := cw.Queue.MarkCrawled(p.URL); err != nil {
+			log.Println("Resume: ", err)
+		}
+	}
+
+	return state.SeedURL, nil
+}
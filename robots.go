@@ -0,0 +1,240 @@
+package crawlbase
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RobotsMode controls how a Crawler treats robots.txt.
+type RobotsMode int
+
+const (
+	// RobotsIgnore never looks at robots.txt (the original behavior).
+	RobotsIgnore RobotsMode = iota
+	// RobotsObey filters urls disallowed by robots.txt.
+	RobotsObey
+	// RobotsObeyCrawlDelay does everything RobotsObey does and also
+	// feeds the per-host crawl delay it declares into CrawlDelayFor.
+	RobotsObeyCrawlDelay
+)
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+// robotsPolicy is the parsed robots.txt for a single scheme+host.
+type robotsPolicy struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// Allowed reports whether path may be fetched under this policy, using
+// the longest-matching-prefix rule (the common robots.txt convention).
+func (p *robotsPolicy) Allowed(path string) bool {
+	allowed := true
+	longestMatch := -1
+	for _, rule := range p.rules {
+		if strings.HasPrefix(path, rule.path) && len(rule.path) > longestMatch {
+			longestMatch = len(rule.path)
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// parseRobotsTxt parses the "User-agent: *" block of a robots.txt body.
+// Per-user-agent overrides aren't supported; every crawler is treated as
+// "*", which is the common simplification for small crawlers.
+func parseRobotsTxt(content string) *robotsPolicy {
+	policy := &robotsPolicy{}
+	applies := false
+
+	for _, line := range SplitByLines(content) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch field {
+		case "user-agent":
+			applies = value == "*"
+		case "disallow":
+			if applies && value != "" {
+				policy.rules = append(policy.rules, robotsRule{allow: false, path: value})
+			}
+		case "allow":
+			if applies && value != "" {
+				policy.rules = append(policy.rules, robotsRule{allow: true, path: value})
+			}
+		case "crawl-delay":
+			if applies {
+				if secs, err := strconv.ParseFloat(value, 64); err == nil {
+					policy.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		}
+	}
+
+	return policy
+}
+
+// followRedirectsClient returns an http.Client that reuses cw.Client's
+// transport (so proxy/TLS settings from SetTransport still apply) but,
+// unlike cw.Client, follows redirects normally. cw.Client refuses every
+// redirect so GetPage records it (the Location target is added to the
+// page's Hrefs) instead of following it, which would otherwise
+// make a redirecting robots.txt or sitemap (bare-domain or http->https
+// canonicalization, both common) silently look like a non-OK response.
+func (cw *Crawler) followRedirectsClient() *http.Client {
+	return &http.Client{
+		Transport: cw.Client.Transport,
+		Timeout:   cw.Client.Timeout,
+	}
+}
+
+// robotsFor fetches and caches the robots.txt policy for u's scheme+host.
+// A missing or unfetchable robots.txt is treated as "allow everything".
+func (cw *Crawler) robotsFor(u *url.URL) *robotsPolicy {
+	key := u.Scheme + "://" + u.Host
+
+	cw.robotsMu.Lock()
+	if cw.robotsCache == nil {
+		cw.robotsCache = map[string]*robotsPolicy{}
+	}
+	if cached, ok := cw.robotsCache[key]; ok {
+		cw.robotsMu.Unlock()
+		return cached
+	}
+	cw.robotsMu.Unlock()
+
+	policy := &robotsPolicy{}
+	res, err := cw.followRedirectsClient().Get(key + "/robots.txt")
+	if err != nil {
+		log.Println("robotsFor: ", err)
+	}
+	if res != nil {
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			if body, err := ioutil.ReadAll(res.Body); err == nil {
+				policy = parseRobotsTxt(string(body))
+			}
+		}
+	}
+
+	cw.robotsMu.Lock()
+	cw.robotsCache[key] = policy
+	cw.robotsMu.Unlock()
+
+	return policy
+}
+
+// isAllowedByRobots reports whether urlStr may be crawled under
+// cw.RobotsPolicy. It's the filter AddAllLinks and AddLinksMatchingDomain
+// apply before handing a url to the VisitQueue.
+func (cw *Crawler) isAllowedByRobots(urlStr string) bool {
+	if cw.RobotsPolicy == RobotsIgnore {
+		return true
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return true
+	}
+	return cw.robotsFor(u).Allowed(u.Path)
+}
+
+// CrawlDelayFor returns how long callers should wait between requests to
+// u's host: WaitBetweenRequests by default, or the host's robots.txt
+// Crawl-delay when that's larger and RobotsPolicy is
+// RobotsObeyCrawlDelay.
+func (cw *Crawler) CrawlDelayFor(u *url.URL) time.Duration {
+	interval := time.Duration(cw.GetWaitBetweenRequests()) * time.Millisecond
+	if cw.RobotsPolicy != RobotsObeyCrawlDelay {
+		return interval
+	}
+	if delay := cw.robotsFor(u).crawlDelay; delay > interval {
+		return delay
+	}
+	return interval
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	URLs    []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// SeedFromSitemap fetches sitemapURL, parses it as either a sitemap index
+// or a urlset (recursing into indexed sitemaps), and enqueues every
+// discovered url the same way AddAllLinks does - including the robots.txt
+// filter.
+func (cw *Crawler) SeedFromSitemap(sitemapURL string) error {
+	return cw.seedFromSitemap(sitemapURL, map[string]bool{})
+}
+
+// seedFromSitemap does the actual work for SeedFromSitemap. seen guards
+// against a sitemap index that (accidentally or maliciously) references
+// itself or an ancestor, which would otherwise recurse forever.
+func (cw *Crawler) seedFromSitemap(sitemapURL string, seen map[string]bool) error {
+	if seen[sitemapURL] {
+		return nil
+	}
+	seen[sitemapURL] = true
+
+	res, err := cw.followRedirectsClient().Get(sitemapURL)
+	if err != nil {
+		log.Println("SeedFromSitemap: ", err)
+	}
+	if res == nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	var index sitemapIndex
+	if err := xml.Unmarshal(body, &index); err == nil && len(index.Sitemaps) > 0 {
+		for _, sm := range index.Sitemaps {
+			if err := cw.seedFromSitemap(sm.Loc, seen); err != nil {
+				log.Println("SeedFromSitemap: ", err)
+			}
+		}
+		return nil
+	}
+
+	var urlset sitemapURLSet
+	if err := xml.Unmarshal(body, &urlset); err != nil {
+		return err
+	}
+
+	links := make([]string, 0, len(urlset.URLs))
+	for _, u := range urlset.URLs {
+		links = append(links, u.Loc)
+	}
+	cw.AddAllLinks(links)
+
+	return nil
+}
@@ -0,0 +1,274 @@
+package crawlbase
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PageSink is an alternate storage backend Crawler.SavePage can dispatch
+// to instead of the default .httpi/.respbin files.
+type PageSink interface {
+	WritePage(page *Page, reqBody, respBody io.Reader) error
+}
+
+// countingWriter tracks how many bytes have been written through it, so
+// WARCWriter can record each record's compressed offset/length.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WARCWriter is a PageSink that writes crawled pages as WARC/1.1 records
+// (warcinfo once per segment, then a request/response pair per page),
+// gzip-per-record so the file stays seekable the way pywb/OpenWayback
+// expect, plus a companion .cdx index.
+type WARCWriter struct {
+	mu      sync.Mutex
+	folder  string
+	prefix  string
+	maxSize int64
+
+	segment     int
+	warcFile    *os.File
+	cdxFile     *os.File
+	currentSize int64
+}
+
+// NewWARCWriter creates a WARCWriter rooted at folder, naming segments
+// "<prefix>-NNNNN.warc.gz" and rotating to a new segment once the current
+// one reaches maxSize bytes (default 1 GiB).
+func NewWARCWriter(folder, prefix string, maxSize int64) (*WARCWriter, error) {
+	if maxSize <= 0 {
+		maxSize = 1 << 30 // 1 GiB
+	}
+	if err := os.MkdirAll(folder, 0777); err != nil {
+		return nil, err
+	}
+
+	w := &WARCWriter{folder: folder, prefix: prefix, maxSize: maxSize}
+	if err := w.openSegment(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// openSegment closes the current segment file (if any) and starts a new
+// one, writing its leading warcinfo record.
+func (w *WARCWriter) openSegment() error {
+	if w.warcFile != nil {
+		w.warcFile.Close()
+	}
+
+	w.segment++
+	name := fmt.Sprintf("%s-%05d.warc.gz", w.prefix, w.segment)
+	f, err := os.Create(path.Join(w.folder, name))
+	if err != nil {
+		return err
+	}
+	w.warcFile = f
+	w.currentSize = 0
+
+	if w.cdxFile == nil {
+		cdx, err := os.OpenFile(path.Join(w.folder, w.prefix+".cdx"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		w.cdxFile = cdx
+		// 9 fields: urlkey date original mimetype statuscode digest
+		// length offset filename - matches writeCDXLine exactly; this
+		// omits the legacy 11-field format's redirect (r) and meta-tags
+		// (M) columns, which writeCDXLine never wrote.
+		if _, err := w.cdxFile.WriteString(" CDX N b a m s k S V g\n"); err != nil {
+			return err
+		}
+	}
+
+	body := []byte("software: crawlbase\r\nformat: WARC File Format 1.1\r\n")
+	_, _, _, _, err = w.writeRecordLocked("warcinfo", "", "application/warc-fields", body)
+	return err
+}
+
+// writeRecordLocked appends a single WARC record, rotating to a new
+// segment first if the current one has reached maxSize. Callers must
+// hold w.mu.
+func (w *WARCWriter) writeRecordLocked(recType, targetURI, contentType string, body []byte) (recordID, digest string, offset, length int64, err error) {
+	if w.currentSize >= w.maxSize {
+		if err = w.openSegment(); err != nil {
+			return
+		}
+	}
+
+	recordID = "urn:uuid:" + newUUID()
+	digestSum := sha1.Sum(body)
+	digest = "sha1:" + base32.StdEncoding.EncodeToString(digestSum[:])
+
+	var header strings.Builder
+	header.WriteString("WARC/1.1\r\n")
+	header.WriteString("WARC-Type: " + recType + "\r\n")
+	header.WriteString("WARC-Record-ID: <" + recordID + ">\r\n")
+	header.WriteString("WARC-Date: " + time.Now().UTC().Format(time.RFC3339) + "\r\n")
+	if targetURI != "" {
+		header.WriteString("WARC-Target-URI: " + targetURI + "\r\n")
+	}
+	header.WriteString("Content-Type: " + contentType + "\r\n")
+	header.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(body)))
+	header.WriteString("WARC-Block-Digest: " + digest + "\r\n")
+	header.WriteString("\r\n")
+
+	record := append([]byte(header.String()), body...)
+	record = append(record, []byte("\r\n\r\n")...)
+
+	offset = w.currentSize
+	cw := &countingWriter{w: w.warcFile}
+	gz := gzip.NewWriter(cw)
+	if _, err = gz.Write(record); err != nil {
+		gz.Close()
+		return
+	}
+	if err = gz.Close(); err != nil {
+		return
+	}
+
+	length = cw.n
+	w.currentSize += length
+	return
+}
+
+func (w *WARCWriter) writeCDXLine(page *Page, digest string, offset, length int64) error {
+	line := fmt.Sprintf("%s %s %s %s %d %s %d %d %s\n",
+		surtLite(page.URL),
+		time.Unix(int64(page.CrawlTime), 0).UTC().Format("20060102150405"),
+		page.URL,
+		page.Response.ContentMIME,
+		page.Response.StatusCode,
+		digest,
+		length,
+		offset,
+		w.warcFile.Name())
+	_, err := w.cdxFile.WriteString(line)
+	return err
+}
+
+// WritePage writes page's request and response as a WARC record pair and
+// appends the matching .cdx line. respBody is read in full. The whole
+// request-record/response-record/cdx-line sequence runs under a single
+// lock acquisition, so a concurrent WritePage can't rotate the segment
+// in between and make the cdx line point at the wrong file.
+func (w *WARCWriter) WritePage(page *Page, reqBody, respBody io.Reader) error {
+	var body []byte
+	if respBody != nil {
+		var err error
+		body, err = ioutil.ReadAll(respBody)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, _, _, _, err := w.writeRecordLocked("request", page.URL, "application/http; msgtype=request", buildHTTPRequestBlock(page)); err != nil {
+		return err
+	}
+
+	_, digest, offset, length, err := w.writeRecordLocked("response", page.URL, "application/http; msgtype=response", buildHTTPResponseBlock(page, body))
+	if err != nil {
+		return err
+	}
+
+	return w.writeCDXLine(page, digest, offset, length)
+}
+
+// Close flushes and closes the current segment and cdx files.
+func (w *WARCWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.warcFile != nil {
+		w.warcFile.Close()
+	}
+	if w.cdxFile != nil {
+		w.cdxFile.Close()
+	}
+	return nil
+}
+
+func buildHTTPRequestBlock(page *Page) []byte {
+	reqPath := "/"
+	if u, err := url.Parse(page.URL); err == nil {
+		reqPath = u.RequestURI()
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("GET %s HTTP/1.1\r\n", reqPath))
+	if page.Request != nil {
+		for k, vs := range page.Request.Header {
+			for _, v := range vs {
+				b.WriteString(k + ": " + v + "\r\n")
+			}
+		}
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+func buildHTTPResponseBlock(page *Page, body []byte) []byte {
+	status := 0
+	var header http.Header
+	if page.Response != nil {
+		status = page.Response.StatusCode
+		header = page.Response.Header
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", status, http.StatusText(status)))
+	for k, vs := range header {
+		for _, v := range vs {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+
+	return append([]byte(b.String()), body...)
+}
+
+// surtLite is a simplified SURT-style key (reversed host + path), enough
+// to sort a .cdx file usefully without implementing the full SURT spec.
+func surtLite(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	parts := strings.Split(u.Host, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, ",") + ")" + u.Path
+}
+
+// newUUID generates a random (v4) UUID without pulling in an external
+// dependency.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}